@@ -0,0 +1,131 @@
+package gcpauth
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/hashicorp/vault-plugin-auth-gcp/plugin/util"
+)
+
+const (
+	// defaultMaxJwtExpMin is the default maximum allowed life for an inbound
+	// login JWT, in minutes, when a role does not override it.
+	defaultMaxJwtExpMin = 15
+
+	// expectedJwtAudTemplate is the expected "aud" claim on an inbound login
+	// JWT, templated with the role name so that a JWT cannot be replayed
+	// against a different role.
+	expectedJwtAudTemplate = "vault/%s"
+
+	// googleIss is the expected "iss" claim on a GCE instance identity token.
+	googleIss = "https://accounts.google.com"
+)
+
+// googleCertsEndpoint is Google's JWKS endpoint used to verify GCE instance
+// identity tokens. Overridden in tests to point at a fake certs server.
+var googleCertsEndpoint = "https://www.googleapis.com/oauth2/v3/certs"
+
+// Factory returns a configured instance of the backend.
+func Factory(conf *logical.BackendConfig) (logical.Backend, error) {
+	b := Backend()
+	if err := b.Setup(conf); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// GcpAuthBackend is the Vault auth backend for GCP IAM and GCE identities.
+type GcpAuthBackend struct {
+	*framework.Backend
+
+	certsMu sync.RWMutex
+	certs   *googleCertsCache
+
+	usedJwtMu    sync.Mutex
+	usedJwtLocks map[string]*sync.Mutex
+}
+
+// Backend constructs an unconfigured instance of the GCP auth backend.
+func Backend() *GcpAuthBackend {
+	b := &GcpAuthBackend{}
+
+	b.Backend = &framework.Backend{
+		Help: backendHelp,
+		// Unauthenticated marks the paths reachable without a Vault token.
+		// login and login/refresh are the credential exchanges themselves;
+		// login/refresh/revoke must also be reachable unauthenticated since
+		// a refresh token is, by design, bearer material independent of any
+		// Vault token, and a caller needs to be able to give one up (e.g.
+		// after a leak) without first obtaining one.
+		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{"login", "login/refresh", "login/refresh/revoke"},
+		},
+		Paths: []*framework.Path{
+			pathConfig(b),
+			pathLogin(b),
+			pathLoginRefresh(b),
+			pathLoginRefreshRevoke(b),
+			pathRole(b),
+			pathRoleList(b),
+		},
+		AuthRenew:    b.pathLoginRenew,
+		PeriodicFunc: b.periodic,
+		BackendType:  logical.TypeCredential,
+	}
+
+	return b
+}
+
+// role fetches and decodes the role with the given name from storage. It
+// returns (nil, nil) if the role does not exist.
+func (b *GcpAuthBackend) role(s logical.Storage, name string) (*gcpRole, error) {
+	entry, err := s.Get(fmt.Sprintf("role/%s", name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	role := &gcpRole{}
+	if err := entry.DecodeJSON(role); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// periodic is invoked on Vault's periodic tick and is responsible for
+// pruning backend-owned storage that would otherwise grow unbounded, such
+// as the used-JWT replay cache.
+func (b *GcpAuthBackend) periodic(s logical.Storage) error {
+	return b.pruneUsedJwts(s)
+}
+
+// computeClient builds a Compute API client from the backend's configured
+// credentials, used to resolve GCE instance metadata (labels, group
+// membership) that isn't present in the instance identity token itself.
+func (b *GcpAuthBackend) computeClient(s logical.Storage) (*compute.Service, error) {
+	config, err := b.config(s)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := util.Credentials(config.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("could not obtain backend credentials: %v", err)
+	}
+
+	httpClient, err := util.GetHttpClient(creds, compute.ComputeReadonlyScope)
+	if err != nil {
+		return nil, err
+	}
+	return compute.New(httpClient)
+}
+
+const backendHelp = `
+The GCP credential provider allows authentication against Vault using
+a signed JWT from either GCP IAM or a GCE instance's identity token.
+`
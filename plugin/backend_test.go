@@ -0,0 +1,66 @@
+package gcpauth
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hashicorp/vault-plugin-auth-gcp/plugin/util"
+	"github.com/hashicorp/vault/logical"
+)
+
+func getTestBackend(t *testing.T) (logical.Backend, logical.Storage) {
+	b := Backend()
+
+	config := &logical.BackendConfig{
+		Logger: nil,
+		System: &logical.StaticSystemView{
+			DefaultLeaseTTLVal: 1800 * 1e9,
+			MaxLeaseTTLVal:     1800 * 1e9,
+		},
+		StorageView: &logical.InmemStorage{},
+	}
+	if err := b.Setup(config); err != nil {
+		t.Fatal(err)
+	}
+
+	return b, config.StorageView
+}
+
+func getTestCredentials() (*util.GcpCredentials, error) {
+	return util.Credentials(os.Getenv(googleCredentialsEnv))
+}
+
+func testConfigUpdate(t *testing.T, b logical.Backend, s logical.Storage, d map[string]interface{}) {
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data:      d,
+		Storage:   s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+}
+
+func testRoleCreate(t *testing.T, b logical.Backend, s logical.Storage, d map[string]interface{}) {
+	name, ok := d["name"].(string)
+	if !ok {
+		t.Fatal("role data must include 'name'")
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/" + name,
+		Data:      d,
+		Storage:   s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+}
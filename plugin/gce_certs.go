@@ -0,0 +1,128 @@
+package gcpauth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// googleCertsCache holds Google's OAuth2 JWKS, refreshed according to the
+// Cache-Control header Google returns, and used to verify the RS256
+// signature on GCE instance identity tokens.
+type googleCertsCache struct {
+	keys    map[string]*rsa.PublicKey
+	expires time.Time
+}
+
+type jwksResponse struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// googleKey returns the RSA public key for the given "kid", fetching and
+// caching Google's JWKS document as needed.
+func (b *GcpAuthBackend) googleKey(kid string) (*rsa.PublicKey, error) {
+	b.certsMu.RLock()
+	cache := b.certs
+	b.certsMu.RUnlock()
+
+	if cache == nil || time.Now().After(cache.expires) {
+		var err error
+		cache, err = fetchGoogleCerts()
+		if err != nil {
+			return nil, err
+		}
+
+		b.certsMu.Lock()
+		b.certs = cache
+		b.certsMu.Unlock()
+	}
+
+	key, ok := cache.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no Google public key found for key id %q", kid)
+	}
+	return key, nil
+}
+
+func fetchGoogleCerts() (*googleCertsCache, error) {
+	resp, err := http.Get(googleCertsEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch Google certs: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks jwksResponse
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		return nil, fmt.Errorf("could not parse Google certs response: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		key, err := jwkToRSAPublicKey(k)
+		if err != nil {
+			return nil, err
+		}
+		keys[k.Kid] = key
+	}
+
+	return &googleCertsCache{
+		keys:    keys,
+		expires: time.Now().Add(cacheControlMaxAge(resp.Header.Get("Cache-Control"))),
+	}, nil
+}
+
+// cacheControlMaxAge parses the max-age directive out of a Cache-Control
+// header, defaulting to one hour if it is missing or malformed.
+func cacheControlMaxAge(header string) time.Duration {
+	const defaultMaxAge = 1 * time.Hour
+
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err != nil {
+			return defaultMaxAge
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultMaxAge
+}
+
+func jwkToRSAPublicKey(k jwk) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode JWK modulus: %v", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode JWK exponent: %v", err)
+	}
+
+	e := new(big.Int).SetBytes(eBytes)
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(e.Int64()),
+	}, nil
+}
@@ -0,0 +1,81 @@
+package gcpauth
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const configStoragePath = "config"
+
+func pathConfig(b *GcpAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config",
+		Fields: map[string]*framework.FieldSchema{
+			"credentials": {
+				Type:        framework.TypeString,
+				Description: `Google credentials JSON that Vault uses to call the GCP APIs.`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathConfigWrite,
+			logical.ReadOperation:   b.pathConfigRead,
+		},
+		HelpSynopsis:    "Configure the GCP credentials used by the backend.",
+		HelpDescription: "This endpoint configures the Google service account credentials used to verify IAM-signed JWTs and call GCP APIs.",
+	}
+}
+
+// gcpConfig holds the backend's own Google credentials, used to make calls
+// against the IAM API (e.g. to verify signing accounts).
+type gcpConfig struct {
+	Credentials string `json:"credentials" structs:"credentials" mapstructure:"credentials"`
+}
+
+func (b *GcpAuthBackend) config(s logical.Storage) (*gcpConfig, error) {
+	entry, err := s.Get(configStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return &gcpConfig{}, nil
+	}
+
+	config := &gcpConfig{}
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, fmt.Errorf("error reading backend configuration: %v", err)
+	}
+	return config, nil
+}
+
+func (b *GcpAuthBackend) pathConfigWrite(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config := &gcpConfig{
+		Credentials: data.Get("credentials").(string),
+	}
+
+	entry, err := logical.StorageEntryJSON(configStoragePath, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *GcpAuthBackend) pathConfigRead(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never echo back the configured credentials JSON - it's a GCP service
+	// account private key. Only report whether one is configured.
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"credentials_configured": config.Credentials != "",
+		},
+	}, nil
+}
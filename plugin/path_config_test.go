@@ -0,0 +1,39 @@
+package gcpauth
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// TestConfig_CredentialsNotReturned guards against reintroducing the
+// credential leak fixed in pathConfigRead: reading config back must never
+// echo the configured credentials JSON, only whether one is set.
+func TestConfig_CredentialsNotReturned(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"credentials": `{"client_email":"test@example.com"}`,
+	})
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected a successful config read, got %v", resp)
+	}
+
+	if _, ok := resp.Data["credentials"]; ok {
+		t.Fatal("expected 'credentials' to not be present in the config read response")
+	}
+
+	configured, ok := resp.Data["credentials_configured"].(bool)
+	if !ok || !configured {
+		t.Fatalf("expected credentials_configured to be true, got %v", resp.Data["credentials_configured"])
+	}
+}
@@ -0,0 +1,246 @@
+package gcpauth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/SermoDigital/jose/jws"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"google.golang.org/api/iam/v1"
+
+	"github.com/hashicorp/vault-plugin-auth-gcp/plugin/util"
+)
+
+func pathLogin(b *GcpAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "login$",
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: "Name of the role against which the login is being attempted.",
+			},
+			"jwt": {
+				Type:        framework.TypeString,
+				Description: "Signed JWT from either GCP IAM or a GCE instance's identity token.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathLogin,
+		},
+		HelpSynopsis:    "Authenticate to Vault using a GCP IAM or GCE identity JWT.",
+		HelpDescription: "Exchanges a signed JWT from either GCP IAM or a GCE instance's identity for a Vault token, as allowed by the named role.",
+	}
+}
+
+func (b *GcpAuthBackend) pathLogin(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("role is required"), nil
+	}
+
+	role, err := b.role(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q not found", roleName)), nil
+	}
+
+	signedJwt := data.Get("jwt").(string)
+	if signedJwt == "" {
+		return logical.ErrorResponse("jwt is required"), nil
+	}
+
+	if role.RoleType == gceRoleType {
+		return b.pathLoginGce(req, role, roleName, signedJwt)
+	}
+
+	resp, err := b.pathLoginIam(req, role, roleName, signedJwt)
+	if err != nil || resp == nil || resp.IsError() || role.RefreshTTL == 0 {
+		return resp, err
+	}
+
+	refreshToken, err := b.mintRefreshToken(req.Storage, roleName, role, resp.Auth.Metadata["service_account_email"], resp.Auth.Metadata["project_id"])
+	if err != nil {
+		return nil, err
+	}
+	resp.Data = map[string]interface{}{
+		"refresh_token": refreshToken,
+	}
+	return resp, nil
+}
+
+func (b *GcpAuthBackend) pathLoginRenew(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName, ok := req.Auth.InternalData["role"].(string)
+	if !ok {
+		return nil, fmt.Errorf("could not determine role from auth data")
+	}
+
+	role, err := b.role(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("role %q no longer exists", roleName)
+	}
+
+	resp := &logical.Response{Auth: req.Auth}
+	resp.Auth.TTL = role.TTL
+	resp.Auth.MaxTTL = role.MaxTTL
+	resp.Auth.Period = role.Period
+	return resp, nil
+}
+
+// maxJwtExp returns the configured maximum lifetime for an inbound login
+// JWT on this role, falling back to the backend default.
+func maxJwtExp(role *gcpRole) time.Duration {
+	if role.MaxJwtExp > 0 {
+		return role.MaxJwtExp
+	}
+	return defaultMaxJwtExpMin * time.Minute
+}
+
+// newAuthResponse builds the logical.Response for a successful login,
+// shared by every role type.
+func newAuthResponse(role *gcpRole, roleName string, metadata map[string]string, personaName string, aliases []*logical.Alias) *logical.Response {
+	return &logical.Response{
+		Auth: &logical.Auth{
+			Policies: role.Policies,
+			Metadata: metadata,
+			InternalData: map[string]interface{}{
+				"role": roleName,
+			},
+			Persona: &logical.Persona{
+				Name: personaName,
+			},
+			GroupAliases: aliases,
+			LeaseOptions: logical.LeaseOptions{
+				Renewable: true,
+				TTL:       role.TTL,
+			},
+			Period: role.Period,
+		},
+	}
+}
+
+// pathLoginIam verifies a Google IAM-signed login JWT and, if it is valid
+// and the signing service account is authorized for the role, returns a
+// Vault token.
+func (b *GcpAuthBackend) pathLoginIam(req *logical.Request, role *gcpRole, roleName, signedJwt string) (*logical.Response, error) {
+	config, err := b.config(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := util.Credentials(config.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("could not obtain backend credentials: %v", err)
+	}
+
+	httpClient, err := util.GetHttpClient(creds, iam.CloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	iamClient, err := iam.New(httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jws.ParseJWT([]byte(signedJwt))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	sub, ok := token.Claims().Subject()
+	if !ok || sub == "" {
+		return logical.ErrorResponse("invalid JWT: no 'sub' claim found"), nil
+	}
+
+	account, err := iamClient.Projects.ServiceAccounts.Get(fmt.Sprintf("projects/-/serviceAccounts/%s", sub)).Do()
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: could not find service account %q: %v", sub, err)), nil
+	}
+
+	kid, _ := token.Protected().Get("kid").(string)
+	key, err := util.GetIamServiceAccountPublicKey(account.Email, kid)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	if err := token.Validate(key, crypto.SigningMethodRS256); err != nil {
+		if strings.Contains(err.Error(), "exp") {
+			return logical.ErrorResponse(fmt.Sprintf("invalid JWT: token is expired: %v", err)), nil
+		}
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	expectedAud := fmt.Sprintf(expectedJwtAudTemplate, roleName)
+	aud, _ := token.Claims().Audience()
+	if len(aud) == 0 || aud[0] != expectedAud {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: 'aud' claim does not match expected audience %q", expectedAud)), nil
+	}
+	if err := checkBoundAudiences(role, aud); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	exp, ok := token.Claims().Expiration()
+	if !ok {
+		return logical.ErrorResponse("invalid JWT: no 'exp' claim found"), nil
+	}
+	if allowed := maxJwtExp(role); exp.Sub(time.Now()) > allowed {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: 'exp' claim too far in the future, must expire within %v", allowed)), nil
+	}
+
+	if err := checkBoundSubject(role, sub); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+	if err := checkBoundClaims(role, token.Claims()); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+	if err := checkBoundCIDRs(role, req); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	if role.ProjectId != "" && account.ProjectId != role.ProjectId {
+		return logical.ErrorResponse(fmt.Sprintf("service account %q (id %q) is not authorized for role %q", account.Email, account.UniqueId, roleName)), nil
+	}
+	if !serviceAccountAuthorized(role.ServiceAccounts, account) {
+		return logical.ErrorResponse(fmt.Sprintf("service account %q (id %q) is not authorized for role %q", account.Email, account.UniqueId, roleName)), nil
+	}
+
+	name, err := personaName(role, token.Claims(), account.UniqueId)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	// Only mark the JWT used once every other check has passed - otherwise
+	// a login rejected for a fixable reason (e.g. a misconfigured
+	// user_claim) permanently burns the token, and a legitimate retry with
+	// the same JWT fails with "already used" instead of succeeding.
+	tokenID, err := b.getTokenID(roleName, sub, token.Claims())
+	if err != nil {
+		return nil, err
+	}
+	if err := b.checkAndRecordUsedJwt(req.Storage, role, tokenID, exp); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	metadata := map[string]string{
+		"service_account_id":    account.UniqueId,
+		"service_account_email": account.Email,
+		"project_id":            account.ProjectId,
+		"role":                  roleName,
+	}
+	return newAuthResponse(role, roleName, metadata, name, groupAliases(role, token.Claims())), nil
+}
+
+func serviceAccountAuthorized(allowed []string, account *iam.ServiceAccount) bool {
+	for _, a := range allowed {
+		if a == "*" || a == account.Email || a == account.UniqueId {
+			return true
+		}
+	}
+	return false
+}
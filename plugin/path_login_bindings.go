@@ -0,0 +1,168 @@
+package gcpauth
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/hashicorp/vault/logical"
+)
+
+// checkBoundSubject enforces role.BoundSubject, if set, against the JWT's
+// "sub" claim.
+func checkBoundSubject(role *gcpRole, sub string) error {
+	if role.BoundSubject == "" {
+		return nil
+	}
+	if sub != role.BoundSubject {
+		return fmt.Errorf("'sub' claim %q does not match bound_subject %q", sub, role.BoundSubject)
+	}
+	return nil
+}
+
+// checkBoundAudiences enforces role.BoundAudiences, if set: at least one of
+// the JWT's "aud" values must appear in the configured list.
+func checkBoundAudiences(role *gcpRole, aud []string) error {
+	if len(role.BoundAudiences) == 0 {
+		return nil
+	}
+	for _, a := range aud {
+		if containsStr(role.BoundAudiences, a) {
+			return nil
+		}
+	}
+	return fmt.Errorf("'aud' claim %v does not match any of bound_audiences %v", aud, role.BoundAudiences)
+}
+
+// checkBoundClaims enforces role.BoundClaims, if set: every configured
+// claim must be present on the JWT and match one of its allowed values,
+// where a "*" anywhere in an allowed value is a glob wildcard.
+func checkBoundClaims(role *gcpRole, claims jwt.Claims) error {
+	for name, allowed := range role.BoundClaims {
+		actual, err := claimStrings(claims.Get(name))
+		if err != nil {
+			return fmt.Errorf("claim %q: %v", name, err)
+		}
+
+		if !anyGlobMatches(allowed, actual) {
+			return fmt.Errorf("claim %q value %v does not match any of %v", name, actual, allowed)
+		}
+	}
+	return nil
+}
+
+// claimStrings normalizes a raw claim value (string, []string, or
+// []interface{} of strings) into a []string.
+func claimStrings(raw interface{}) ([]string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, fmt.Errorf("claim not present")
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string claim values, got %T", item)
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported claim value type %T", v)
+	}
+}
+
+// anyGlobMatches reports whether any actual value matches any allowed
+// pattern, where allowed patterns may use "*" as a glob wildcard.
+func anyGlobMatches(allowed, actual []string) bool {
+	for _, pattern := range allowed {
+		for _, a := range actual {
+			if globMatch(pattern, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func globMatch(pattern, s string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == s
+	}
+	ok, err := path.Match(pattern, s)
+	return err == nil && ok
+}
+
+// checkBoundCIDRs enforces role.BoundCIDRs, if set, against the login
+// request's remote address.
+func checkBoundCIDRs(role *gcpRole, req *logical.Request) error {
+	if len(role.BoundCIDRs) == 0 {
+		return nil
+	}
+	if req.Connection == nil || req.Connection.RemoteAddr == "" {
+		return fmt.Errorf("could not determine remote address for bound_cidrs check")
+	}
+
+	ip := net.ParseIP(req.Connection.RemoteAddr)
+	if ip == nil {
+		host, _, err := net.SplitHostPort(req.Connection.RemoteAddr)
+		if err != nil {
+			return fmt.Errorf("could not parse remote address %q", req.Connection.RemoteAddr)
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return fmt.Errorf("could not parse remote address %q", req.Connection.RemoteAddr)
+	}
+
+	for _, cidr := range role.BoundCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid bound_cidrs entry %q: %v", cidr, err)
+		}
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("remote address %q is not in bound_cidrs %v", ip, role.BoundCIDRs)
+}
+
+// personaName returns the value to use for Auth.Persona.Name: role.UserClaim
+// from the JWT if configured, otherwise the type-specific default (service
+// account ID or instance ID).
+func personaName(role *gcpRole, claims jwt.Claims, defaultName string) (string, error) {
+	if role.UserClaim == "" {
+		return defaultName, nil
+	}
+
+	vals, err := claimStrings(claims.Get(role.UserClaim))
+	if err != nil || len(vals) == 0 {
+		return "", fmt.Errorf("user_claim %q not found on JWT", role.UserClaim)
+	}
+	return vals[0], nil
+}
+
+// groupAliases returns the Vault group aliases implied by role.GroupsClaim,
+// if configured.
+func groupAliases(role *gcpRole, claims jwt.Claims) []*logical.Alias {
+	if role.GroupsClaim == "" {
+		return nil
+	}
+
+	vals, err := claimStrings(claims.Get(role.GroupsClaim))
+	if err != nil {
+		return nil
+	}
+
+	aliases := make([]*logical.Alias, 0, len(vals))
+	for _, v := range vals {
+		aliases = append(aliases, &logical.Alias{Name: v})
+	}
+	return aliases
+}
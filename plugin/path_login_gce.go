@@ -0,0 +1,272 @@
+package gcpauth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/SermoDigital/jose/jws"
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/hashicorp/vault/logical"
+	"google.golang.org/api/compute/v1"
+)
+
+// expectedGoogleIss is the "iss" claim Google sets on every instance
+// identity token.
+const expectedGoogleIss = googleIss
+
+// computeEngineClaim mirrors the "google.compute_engine" claim Google
+// embeds in a GCE instance identity token.
+type computeEngineClaim struct {
+	ProjectId            string   `mapstructure:"project_id"`
+	Zone                 string   `mapstructure:"zone"`
+	InstanceId           string   `mapstructure:"instance_id"`
+	InstanceName         string   `mapstructure:"instance_name"`
+	InstanceCreationTime string   `mapstructure:"instance_creation_timestamp"`
+	LicenseId            []string `mapstructure:"license_id"`
+}
+
+// pathLoginGce verifies a GCE instance identity JWT (signed by Google, not
+// the caller) and, if the instance satisfies the role's bound_* fields,
+// returns a Vault token.
+func (b *GcpAuthBackend) pathLoginGce(req *logical.Request, role *gcpRole, roleName, signedJwt string) (*logical.Response, error) {
+	token, err := jws.ParseJWT([]byte(signedJwt))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	kid, _ := token.Protected().Get("kid").(string)
+	key, err := b.googleKey(kid)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	if err := token.Validate(key, crypto.SigningMethodRS256); err != nil {
+		if strings.Contains(err.Error(), "exp") {
+			return logical.ErrorResponse(fmt.Sprintf("invalid JWT: token is expired: %v", err)), nil
+		}
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	claims := token.Claims()
+
+	if iss, _ := claims.Issuer(); iss != expectedGoogleIss {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: 'iss' claim %q does not match expected issuer %q", iss, expectedGoogleIss)), nil
+	}
+
+	expectedAud := fmt.Sprintf(expectedJwtAudTemplate, roleName)
+	aud, _ := claims.Audience()
+	if len(aud) == 0 || aud[0] != expectedAud {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: 'aud' claim does not match expected audience %q", expectedAud)), nil
+	}
+	if err := checkBoundAudiences(role, aud); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	exp, ok := claims.Expiration()
+	if !ok {
+		return logical.ErrorResponse("invalid JWT: no 'exp' claim found"), nil
+	}
+	if allowed := maxJwtExp(role); exp.Sub(time.Now()) > allowed {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: 'exp' claim too far in the future, must expire within %v", allowed)), nil
+	}
+
+	if sub, _ := claims.Subject(); sub != "" {
+		if err := checkBoundSubject(role, sub); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+		}
+	}
+	if err := checkBoundClaims(role, claims); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+	if err := checkBoundCIDRs(role, req); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	gce, err := parseComputeEngineClaim(claims)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	if err := authorizeGceInstanceClaims(role, gce); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("instance %q is not authorized for role %q: %v", gce.InstanceName, roleName, err)), nil
+	}
+	if err := b.authorizeGceInstanceBindings(req.Storage, role, gce); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("instance %q is not authorized for role %q: %v", gce.InstanceName, roleName, err)), nil
+	}
+
+	name, err := personaName(role, claims, gce.InstanceId)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	// Only mark the JWT used once every other check has passed - otherwise
+	// a login rejected for a fixable reason (e.g. a misconfigured
+	// user_claim) permanently burns the token, and a legitimate retry with
+	// the same JWT fails with "already used" instead of succeeding.
+	tokenID, err := b.getTokenID(roleName, gce.InstanceId, claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.checkAndRecordUsedJwt(req.Storage, role, tokenID, exp); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid JWT: %v", err)), nil
+	}
+
+	metadata := map[string]string{
+		"project_id":    gce.ProjectId,
+		"zone":          gce.Zone,
+		"instance_id":   gce.InstanceId,
+		"instance_name": gce.InstanceName,
+		"license_id":    strings.Join(gce.LicenseId, ","),
+		"role":          roleName,
+	}
+	return newAuthResponse(role, roleName, metadata, name, groupAliases(role, claims)), nil
+}
+
+func parseComputeEngineClaim(claims jwt.Claims) (*computeEngineClaim, error) {
+	raw, ok := claims.Get("google").(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no 'google' claim found")
+	}
+	ceRaw, ok := raw["compute_engine"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no 'google.compute_engine' claim found")
+	}
+
+	gce := &computeEngineClaim{
+		ProjectId:            stringField(ceRaw, "project_id"),
+		Zone:                 stringField(ceRaw, "zone"),
+		InstanceId:           stringField(ceRaw, "instance_id"),
+		InstanceName:         stringField(ceRaw, "instance_name"),
+		InstanceCreationTime: stringField(ceRaw, "instance_creation_timestamp"),
+		LicenseId:            stringSliceField(ceRaw, "license_id"),
+	}
+	return gce, nil
+}
+
+func stringField(m map[string]interface{}, key string) string {
+	switch v := m[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+func stringSliceField(m map[string]interface{}, key string) []string {
+	raw, ok := m[key].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	out := make([]string, 0, len(raw))
+	for _, item := range raw {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// authorizeGceInstanceClaims checks the instance's identity-token claims
+// against the role's bound_* fields that don't require any further API
+// calls. All configured bounds must match; unset bounds are not checked.
+func authorizeGceInstanceClaims(role *gcpRole, gce *computeEngineClaim) error {
+	if role.ProjectId != "" && gce.ProjectId != role.ProjectId {
+		return fmt.Errorf("project %q is not bound to role", gce.ProjectId)
+	}
+	if len(role.BoundProjects) > 0 && !containsStr(role.BoundProjects, gce.ProjectId) {
+		return fmt.Errorf("project %q is not in bound_projects", gce.ProjectId)
+	}
+	if len(role.BoundZones) > 0 && !containsStr(role.BoundZones, gce.Zone) {
+		return fmt.Errorf("zone %q is not in bound_zones", gce.Zone)
+	}
+	if len(role.BoundRegions) > 0 && !containsStr(role.BoundRegions, regionFromZone(gce.Zone)) {
+		return fmt.Errorf("region %q is not in bound_regions", regionFromZone(gce.Zone))
+	}
+	return nil
+}
+
+// authorizeGceInstanceBindings checks bound_labels and bound_instance_groups,
+// which aren't part of the identity token and must be verified by calling
+// the Compute API with the backend's configured credentials.
+func (b *GcpAuthBackend) authorizeGceInstanceBindings(s logical.Storage, role *gcpRole, gce *computeEngineClaim) error {
+	if len(role.BoundLabels) == 0 && len(role.BoundInstanceGroups) == 0 {
+		return nil
+	}
+
+	computeClient, err := b.computeClient(s)
+	if err != nil {
+		return err
+	}
+
+	if len(role.BoundLabels) > 0 {
+		instance, err := computeClient.Instances.Get(gce.ProjectId, gce.Zone, gce.InstanceName).Do()
+		if err != nil {
+			return fmt.Errorf("could not look up instance labels: %v", err)
+		}
+		for k, v := range role.BoundLabels {
+			if instance.Labels[k] != v {
+				return fmt.Errorf("label %q is not set to %q", k, v)
+			}
+		}
+	}
+
+	if len(role.BoundInstanceGroups) > 0 {
+		var matched bool
+		for _, group := range role.BoundInstanceGroups {
+			ok, err := instanceInGroup(computeClient, gce, group)
+			if err != nil {
+				return err
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("instance is not a member of any of bound_instance_groups")
+		}
+	}
+
+	return nil
+}
+
+func instanceInGroup(computeClient *compute.Service, gce *computeEngineClaim, group string) (bool, error) {
+	resp, err := computeClient.InstanceGroups.ListInstances(gce.ProjectId, gce.Zone, group, &compute.InstanceGroupsListInstancesRequest{
+		InstanceState: "ALL",
+	}).Do()
+	if err != nil {
+		return false, fmt.Errorf("could not list instances for instance group %q: %v", group, err)
+	}
+
+	for _, item := range resp.Items {
+		if strings.HasSuffix(item.Instance, "/"+gce.InstanceName) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func containsStr(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// regionFromZone derives a GCE region from a zone name, e.g. "us-central1-a"
+// becomes "us-central1".
+func regionFromZone(zone string) string {
+	idx := strings.LastIndex(zone, "-")
+	if idx < 0 {
+		return zone
+	}
+	return zone[:idx]
+}
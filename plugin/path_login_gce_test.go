@@ -0,0 +1,269 @@
+package gcpauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SermoDigital/jose"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/hashicorp/vault/logical"
+)
+
+const testGceKid = "test-gce-key"
+
+// gceTestCertServer serves a fake Google JWKS document backed by an in-test
+// RSA key, so TestLoginGce* don't need network access or real GCE metadata.
+func gceTestCertServer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwks := jwksResponse{
+			Keys: []jwk{
+				{
+					Kid: testGceKid,
+					Kty: "RSA",
+					Alg: "RS256",
+					N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+					E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+				},
+			},
+		}
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		json.NewEncoder(w).Encode(jwks)
+	}))
+}
+
+func getTestGceToken(t *testing.T, roleName string, key *rsa.PrivateKey, gce computeEngineClaim, expDelta time.Duration) string {
+	header, err := jose.Protected{
+		"alg": crypto.SigningMethodRS256.Alg(),
+		"kid": testGceKid,
+		"typ": "JWT",
+	}.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.Claims{}
+	claims.SetIssuer(googleIss)
+	claims.SetAudience(fmt.Sprintf(expectedJwtAudTemplate, roleName))
+	claims.SetExpiration(time.Now().Add(expDelta))
+	claims.Set("google", map[string]interface{}{
+		"compute_engine": map[string]interface{}{
+			"project_id":    gce.ProjectId,
+			"zone":          gce.Zone,
+			"instance_id":   gce.InstanceId,
+			"instance_name": gce.InstanceName,
+		},
+	})
+	claimBytes, err := claims.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toSign := fmt.Sprintf("%s.%s", string(header), string(claimBytes))
+	sig, err := crypto.SigningMethodRS256.Sign([]byte(toSign), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig64, err := sig.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fmt.Sprintf("%s.%s", toSign, sig64)
+}
+
+func testLoginGceSetup(t *testing.T) (*GcpAuthBackend, logical.Storage, *rsa.PrivateKey, *httptest.Server) {
+	backendIface, reqStorage := getTestBackend(t)
+	b := backendIface.(*GcpAuthBackend)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := gceTestCertServer(t, key)
+	googleCertsEndpoint = server.URL
+
+	return b, reqStorage, key, server
+}
+
+func TestLoginGce(t *testing.T) {
+	b, reqStorage, key, server := testLoginGceSetup(t)
+	defer server.Close()
+
+	roleName := "testgcerole"
+	testRoleCreate(t, b, reqStorage, map[string]interface{}{
+		"name":           roleName,
+		"type":           "gce",
+		"project_id":     "my-project",
+		"bound_zones":    "us-central1-a",
+		"bound_projects": "my-project",
+	})
+
+	gce := computeEngineClaim{
+		ProjectId:    "my-project",
+		Zone:         "us-central1-a",
+		InstanceId:   "1234567890",
+		InstanceName: "my-instance",
+	}
+	jwtVal := getTestGceToken(t, roleName, key, gce, 10*time.Minute)
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Data: map[string]interface{}{
+			"role": roleName,
+			"jwt":  jwtVal,
+		},
+		Storage: reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+
+	if resp.Auth.Persona.Name != gce.InstanceId {
+		t.Fatalf("expected persona name %q, got %q", gce.InstanceId, resp.Auth.Persona.Name)
+	}
+	if resp.Auth.Metadata["instance_name"] != gce.InstanceName {
+		t.Fatalf("expected instance_name metadata %q, got %q", gce.InstanceName, resp.Auth.Metadata["instance_name"])
+	}
+}
+
+func TestLoginGce_BadAudience(t *testing.T) {
+	b, reqStorage, key, server := testLoginGceSetup(t)
+	defer server.Close()
+
+	roleName := "testgcerole"
+	testRoleCreate(t, b, reqStorage, map[string]interface{}{
+		"name":       roleName,
+		"type":       "gce",
+		"project_id": "my-project",
+	})
+
+	gce := computeEngineClaim{ProjectId: "my-project", Zone: "us-central1-a", InstanceId: "1", InstanceName: "i"}
+	jwtVal := getTestGceToken(t, "someotherrole", key, gce, 10*time.Minute)
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Data: map[string]interface{}{
+			"role": roleName,
+			"jwt":  jwtVal,
+		},
+		Storage: reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Fatal("expected error response for mismatched audience")
+	}
+}
+
+func TestLoginGce_UnboundProject(t *testing.T) {
+	b, reqStorage, key, server := testLoginGceSetup(t)
+	defer server.Close()
+
+	roleName := "testgcerole"
+	testRoleCreate(t, b, reqStorage, map[string]interface{}{
+		"name":           roleName,
+		"type":           "gce",
+		"project_id":     "my-project",
+		"bound_projects": "some-other-project",
+	})
+
+	gce := computeEngineClaim{ProjectId: "my-project", Zone: "us-central1-a", InstanceId: "1", InstanceName: "i"}
+	jwtVal := getTestGceToken(t, roleName, key, gce, 10*time.Minute)
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Data: map[string]interface{}{
+			"role": roleName,
+			"jwt":  jwtVal,
+		},
+		Storage: reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Fatal("expected error response for unbound project")
+	}
+}
+
+func TestLoginGce_Replay(t *testing.T) {
+	b, reqStorage, key, server := testLoginGceSetup(t)
+	defer server.Close()
+
+	roleName := "testgcerole"
+	testRoleCreate(t, b, reqStorage, map[string]interface{}{
+		"name":       roleName,
+		"type":       "gce",
+		"project_id": "my-project",
+	})
+
+	gce := computeEngineClaim{ProjectId: "my-project", Zone: "us-central1-a", InstanceId: "1", InstanceName: "i"}
+	jwtVal := getTestGceToken(t, roleName, key, gce, 10*time.Minute)
+	loginData := map[string]interface{}{
+		"role": roleName,
+		"jwt":  jwtVal,
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Data:      loginData,
+		Storage:   reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+
+	testLoginError(t, b, reqStorage, loginData, []string{"already used"})
+}
+
+func TestLoginGce_ExpiredToken(t *testing.T) {
+	b, reqStorage, key, server := testLoginGceSetup(t)
+	defer server.Close()
+
+	roleName := "testgcerole"
+	testRoleCreate(t, b, reqStorage, map[string]interface{}{
+		"name":       roleName,
+		"type":       "gce",
+		"project_id": "my-project",
+	})
+
+	gce := computeEngineClaim{ProjectId: "my-project", Zone: "us-central1-a", InstanceId: "1", InstanceName: "i"}
+	jwtVal := getTestGceToken(t, roleName, key, gce, -10*time.Minute)
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Data: map[string]interface{}{
+			"role": roleName,
+			"jwt":  jwtVal,
+		},
+		Storage: reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Fatal("expected error response for expired token")
+	}
+}
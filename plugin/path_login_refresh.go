@@ -0,0 +1,219 @@
+package gcpauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"google.golang.org/api/iam/v1"
+
+	"github.com/hashicorp/vault-plugin-auth-gcp/plugin/util"
+)
+
+// refreshStoragePrefix is where outstanding refresh tokens are recorded,
+// keyed by a hash of the opaque token value so storage never holds the
+// token itself.
+const refreshStoragePrefix = "refresh/"
+
+// refreshEntry is what's stored per outstanding refresh token.
+type refreshEntry struct {
+	Role                string    `json:"role"`
+	ServiceAccountEmail string    `json:"service_account_email"`
+	ProjectId           string    `json:"project_id"`
+	Expiry              time.Time `json:"expiry"`
+	Generation          int       `json:"generation"`
+}
+
+func pathLoginRefresh(b *GcpAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "login/refresh$",
+		Fields: map[string]*framework.FieldSchema{
+			"refresh_token": {
+				Type:        framework.TypeString,
+				Description: "Refresh token issued at a previous login.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathLoginRefresh,
+		},
+		HelpSynopsis:    "Renew a Vault token past max_ttl using a previously issued refresh token.",
+		HelpDescription: "Exchanges a refresh token issued by a prior IAM login for a new Vault token, re-signing a short-lived login JWT with the plugin's own credentials.",
+	}
+}
+
+func pathLoginRefreshRevoke(b *GcpAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "login/refresh/revoke$",
+		Fields: map[string]*framework.FieldSchema{
+			"refresh_token": {
+				Type:        framework.TypeString,
+				Description: "Refresh token to revoke.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathLoginRefreshRevoke,
+		},
+		HelpSynopsis: "Revoke a refresh token before it expires.",
+	}
+}
+
+func (b *GcpAuthBackend) pathLoginRefresh(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	refreshToken := data.Get("refresh_token").(string)
+	if refreshToken == "" {
+		return logical.ErrorResponse("refresh_token is required"), nil
+	}
+
+	hash := hashRefreshToken(refreshToken)
+	path := refreshStoragePrefix + hash
+
+	entry, err := b.refreshEntry(req.Storage, path)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("refresh token not found or already used"), nil
+	}
+	if time.Now().After(entry.Expiry) {
+		if err := req.Storage.Delete(path); err != nil {
+			return nil, err
+		}
+		return logical.ErrorResponse("refresh token has expired"), nil
+	}
+
+	role, err := b.role(req.Storage, entry.Role)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q no longer exists", entry.Role)), nil
+	}
+
+	config, err := b.config(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := util.Credentials(config.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("could not obtain backend credentials: %v", err)
+	}
+	httpClient, err := util.GetHttpClient(creds, iam.CloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	iamClient, err := iam.New(httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	aud := fmt.Sprintf(expectedJwtAudTemplate, entry.Role)
+	signed, err := util.ServiceAccountLoginJwt(iamClient, time.Now().Add(maxJwtExp(role)), aud, entry.ProjectId, entry.ServiceAccountEmail)
+	if err != nil {
+		return nil, fmt.Errorf("could not re-sign login JWT: %v", err)
+	}
+
+	resp, err := b.pathLoginIam(req, role, entry.Role, signed.SignedJwt)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return resp, nil
+	}
+
+	if err := req.Storage.Delete(path); err != nil {
+		return nil, err
+	}
+
+	newToken, _, err := b.storeRefreshEntry(req.Storage, &refreshEntry{
+		Role:                entry.Role,
+		ServiceAccountEmail: entry.ServiceAccountEmail,
+		ProjectId:           entry.ProjectId,
+		Expiry:              entry.Expiry,
+		Generation:          entry.Generation + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Data = map[string]interface{}{
+		"refresh_token": newToken,
+	}
+	return resp, nil
+}
+
+func (b *GcpAuthBackend) pathLoginRefreshRevoke(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	refreshToken := data.Get("refresh_token").(string)
+	if refreshToken == "" {
+		return logical.ErrorResponse("refresh_token is required"), nil
+	}
+
+	return nil, req.Storage.Delete(refreshStoragePrefix + hashRefreshToken(refreshToken))
+}
+
+// mintRefreshToken issues a new refresh token for a successful IAM login on
+// a role with refresh_ttl set, returning the opaque token to hand back to
+// the caller.
+func (b *GcpAuthBackend) mintRefreshToken(s logical.Storage, roleName string, role *gcpRole, serviceAccountEmail, projectId string) (string, error) {
+	token, _, err := b.storeRefreshEntry(s, &refreshEntry{
+		Role:                roleName,
+		ServiceAccountEmail: serviceAccountEmail,
+		ProjectId:           projectId,
+		Expiry:              time.Now().Add(role.RefreshTTL),
+		Generation:          1,
+	})
+	return token, err
+}
+
+// storeRefreshEntry generates a new opaque refresh token, stores the given
+// entry under a hash of it, and returns the token and the storage path the
+// entry was written to.
+func (b *GcpAuthBackend) storeRefreshEntry(s logical.Storage, e *refreshEntry) (string, string, error) {
+	token, err := generateRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	path := refreshStoragePrefix + hashRefreshToken(token)
+	storageEntry, err := logical.StorageEntryJSON(path, e)
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.Put(storageEntry); err != nil {
+		return "", "", err
+	}
+
+	return token, path, nil
+}
+
+func (b *GcpAuthBackend) refreshEntry(s logical.Storage, path string) (*refreshEntry, error) {
+	storageEntry, err := s.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if storageEntry == nil {
+		return nil, nil
+	}
+
+	entry := &refreshEntry{}
+	if err := storageEntry.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func generateRefreshToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("could not generate refresh token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,130 @@
+package gcpauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/hashicorp/vault/logical"
+)
+
+// usedJwtStoragePrefix is where token IDs of already-used login JWTs are
+// recorded, to detect replay.
+const usedJwtStoragePrefix = "login/used-jwts/"
+
+// usedJwtEntry is stored at login/used-jwts/<id> once a JWT with that ID has
+// been used to log in.
+type usedJwtEntry struct {
+	Expires time.Time `json:"expires"`
+}
+
+// getTokenID returns a stable identifier for a login JWT, used to detect
+// replay. This mirrors smallstep's GetTokenID: prefer the "jti" claim when
+// present, and otherwise fall back to a deterministic hash of whatever
+// makes this login unique - the role it was presented to, the caller's
+// identity, and the token's validity window. The "jti" claim is caller-
+// controlled and not used directly as a storage-path component - it's
+// always hashed first, the same way hashRefreshToken hashes refresh tokens,
+// so an adversarial value (e.g. containing "/") can't create a nested
+// storage key that pruneUsedJwts then fails to clean up.
+func (b *GcpAuthBackend) getTokenID(roleName, subject string, claims jwt.Claims) (string, error) {
+	if jti, ok := claims.Get("jti").(string); ok && jti != "" {
+		return hashUsedJwtID(jti), nil
+	}
+
+	iat, _ := claims.IssuedAt()
+	exp, _ := claims.Expiration()
+	return hashUsedJwtID(fmt.Sprintf("%s|%s|%d|%d", roleName, subject, iat.Unix(), exp.Unix())), nil
+}
+
+func hashUsedJwtID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkAndRecordUsedJwt rejects a login whose token ID has already been
+// used, unless the role has allow_jwt_reuse set. On success, it records the
+// ID with a TTL equal to the JWT's own remaining lifetime.
+//
+// The check-then-write below isn't atomic from storage's point of view, so
+// it additionally serializes on the token ID itself (mirroring certsMu in
+// gce_certs.go) - otherwise two concurrent logins presenting the identical
+// captured JWT could both pass the "does it already exist" read before
+// either one's write lands, and both would succeed.
+func (b *GcpAuthBackend) checkAndRecordUsedJwt(s logical.Storage, role *gcpRole, id string, expires time.Time) error {
+	if role.AllowJwtReuse {
+		return nil
+	}
+
+	unlock := b.lockUsedJwtID(id)
+	defer unlock()
+
+	path := usedJwtStoragePrefix + id
+	existing, err := s.Get(path)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("JWT already used")
+	}
+
+	entry, err := logical.StorageEntryJSON(path, &usedJwtEntry{Expires: expires})
+	if err != nil {
+		return err
+	}
+	return s.Put(entry)
+}
+
+// lockUsedJwtID locks a per-token-ID mutex, creating it if necessary, and
+// returns a function to unlock it.
+func (b *GcpAuthBackend) lockUsedJwtID(id string) func() {
+	b.usedJwtMu.Lock()
+	if b.usedJwtLocks == nil {
+		b.usedJwtLocks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := b.usedJwtLocks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		b.usedJwtLocks[id] = lock
+	}
+	b.usedJwtMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// pruneUsedJwts deletes used-JWT entries past their expiry, so the replay
+// cache doesn't grow without bound. Invoked on the backend's periodic tick.
+func (b *GcpAuthBackend) pruneUsedJwts(s logical.Storage) error {
+	keys, err := s.List(usedJwtStoragePrefix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		path := usedJwtStoragePrefix + key
+
+		entry, err := s.Get(path)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+
+		used := &usedJwtEntry{}
+		if err := entry.DecodeJSON(used); err != nil {
+			return err
+		}
+		if now.After(used.Expires) {
+			if err := s.Delete(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
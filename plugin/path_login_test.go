@@ -261,9 +261,153 @@ func TestLoginIam_JwtExpiresTime(t *testing.T) {
 
 }
 
+// TestLoginIam_Replay checks that the same login JWT cannot be used twice.
+func TestLoginIam_Replay(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	creds, err := getTestCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"credentials": os.Getenv(googleCredentialsEnv),
+	})
+
+	roleName := "testrole"
+	testRoleCreate(t, b, reqStorage, map[string]interface{}{
+		"name":             roleName,
+		"type":             "iam",
+		"project_id":       creds.ProjectId,
+		"service_accounts": creds.ClientEmail,
+	})
+
+	expDelta := time.Duration(defaultMaxJwtExpMin-5) * time.Minute
+	jwtVal := getTestIamToken(t, roleName, creds, expDelta)
+	loginData := map[string]interface{}{
+		"role": roleName,
+		"jwt":  jwtVal,
+	}
+
+	metadata := map[string]string{
+		"service_account_id":    creds.ClientId,
+		"service_account_email": creds.ClientEmail,
+		"role":                  roleName,
+	}
+	role := &gcpRole{
+		RoleType:        "iam",
+		ProjectId:       creds.ProjectId,
+		Policies:        []string{"default"},
+		ServiceAccounts: []string{creds.ClientEmail},
+	}
+	testLoginIam(t, b, reqStorage, loginData, metadata, role, creds.ClientId)
+
+	testLoginError(t, b, reqStorage, loginData, []string{"already used"})
+}
+
+// TestLoginIam_Refresh logs in against a role with refresh_ttl set, then
+// refreshes the resulting refresh token three times, asserting each
+// refresh yields a new Vault token and invalidates the previous refresh
+// token.
+func TestLoginIam_Refresh(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	creds, err := getTestCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"credentials": os.Getenv(googleCredentialsEnv),
+	})
+
+	roleName := "testrefreshrole"
+	testRoleCreate(t, b, reqStorage, map[string]interface{}{
+		"name":             roleName,
+		"type":             "iam",
+		"project_id":       creds.ProjectId,
+		"service_accounts": creds.ClientEmail,
+		"refresh_ttl":      3600,
+	})
+
+	expDelta := time.Duration(defaultMaxJwtExpMin-5) * time.Minute
+	jwtVal := getTestIamToken(t, roleName, creds, expDelta)
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Data: map[string]interface{}{
+			"role": roleName,
+			"jwt":  jwtVal,
+		},
+		Storage: reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+
+	refreshToken, ok := resp.Data["refresh_token"].(string)
+	if !ok || refreshToken == "" {
+		t.Fatal("expected a refresh_token in the login response")
+	}
+
+	seenTokens := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		resp, err := b.HandleRequest(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/refresh",
+			Data: map[string]interface{}{
+				"refresh_token": refreshToken,
+			},
+			Storage: reqStorage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp != nil && resp.IsError() {
+			t.Fatalf("refresh %d failed: %v", i, resp.Error())
+		}
+
+		if resp.Auth == nil {
+			t.Fatalf("expected an auth response on refresh %d", i)
+		}
+
+		oldRefreshToken := refreshToken
+		refreshToken, ok = resp.Data["refresh_token"].(string)
+		if !ok || refreshToken == "" {
+			t.Fatalf("expected a rotated refresh_token on refresh %d", i)
+		}
+		if refreshToken == oldRefreshToken {
+			t.Fatalf("expected refresh token to rotate on refresh %d", i)
+		}
+		if seenTokens[refreshToken] {
+			t.Fatalf("expected a never-before-seen refresh token on refresh %d", i)
+		}
+		seenTokens[oldRefreshToken] = true
+
+		revokeResp, err := b.HandleRequest(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/refresh",
+			Data: map[string]interface{}{
+				"refresh_token": oldRefreshToken,
+			},
+			Storage: reqStorage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !revokeResp.IsError() {
+			t.Fatalf("expected previous refresh token to be rejected after refresh %d", i)
+		}
+	}
+}
+
 func testLoginIam(
 	t *testing.T, b logical.Backend, s logical.Storage,
-	d map[string]interface{}, expectedMetadata map[string]string, role *gcpRole, personaName string) {
+	d map[string]interface{}, expectedMetadata map[string]string, role *gcpRole, personaName string, expectedGroups ...string) {
 	resp, err := b.HandleRequest(&logical.Request{
 		Operation: logical.UpdateOperation,
 		Path:      "login",
@@ -301,6 +445,17 @@ func testLoginIam(
 		t.Fatalf("expected persona with name %s, got %s", personaName, resp.Auth.Persona.Name)
 	}
 
+	if len(expectedGroups) > 0 {
+		if len(resp.Auth.GroupAliases) != len(expectedGroups) {
+			t.Fatalf("expected group aliases %v, got %v", expectedGroups, resp.Auth.GroupAliases)
+		}
+		for i, expected := range expectedGroups {
+			if resp.Auth.GroupAliases[i].Name != expected {
+				t.Fatalf("expected group alias %q at index %d, got %q", expected, i, resp.Auth.GroupAliases[i].Name)
+			}
+		}
+	}
+
 	// Check lease options
 	if !resp.Auth.LeaseOptions.Renewable {
 		t.Fatal("expected lease options to be renewable")
@@ -311,11 +466,16 @@ func testLoginIam(
 }
 
 func testLoginError(t *testing.T, b logical.Backend, s logical.Storage, d map[string]interface{}, errorSubstrings []string) {
+	testLoginErrorWithConnection(t, b, s, d, nil, errorSubstrings)
+}
+
+func testLoginErrorWithConnection(t *testing.T, b logical.Backend, s logical.Storage, d map[string]interface{}, connection *logical.Connection, errorSubstrings []string) {
 	resp, err := b.HandleRequest(&logical.Request{
-		Operation: logical.UpdateOperation,
-		Path:      "login",
-		Data:      d,
-		Storage:   s,
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Data:       d,
+		Storage:    s,
+		Connection: connection,
 	})
 
 	if err != nil {
@@ -395,3 +555,197 @@ func createExpiredIamToken(t *testing.T, roleName string, creds *util.GcpCredent
 
 	return fmt.Sprintf("%s.%s", toSign, sig64)
 }
+
+// createIamTokenWithClaims is like createExpiredIamToken, but signs a
+// not-yet-expired, self-signed token with arbitrary extra claims, so bound
+// claim constraints can be exercised without relying on what Google's own
+// IAM signJwt API happens to put in the token.
+func createIamTokenWithClaims(t *testing.T, roleName string, creds *util.GcpCredentials, extra map[string]interface{}) string {
+	header, err := jose.Protected{
+		"alg": crypto.SigningMethodRS256.Alg(),
+		"kid": creds.PrivateKeyId,
+		"typ": "JWT",
+	}.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.Claims{}
+	claims.SetAudience(fmt.Sprintf(expectedJwtAudTemplate, roleName))
+	claims.SetSubject(creds.ClientId)
+	claims.SetExpiration(time.Now().Add(10 * time.Minute))
+	for k, v := range extra {
+		claims.Set(k, v)
+	}
+	claimBytes, err := claims.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toSign := fmt.Sprintf("%s.%s", string(header), string(claimBytes))
+	key, err := crypto.ParseRSAPrivateKeyFromPEM([]byte(creds.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := crypto.SigningMethodRS256.Sign([]byte(toSign), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig64, err := sig.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fmt.Sprintf("%s.%s", toSign, sig64)
+}
+
+// TestLoginIam_BoundClaims runs each bound_* constraint added to gcpRole
+// through a success and a failure case.
+func TestLoginIam_BoundClaims(t *testing.T) {
+	creds, err := getTestCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name        string
+		roleData    map[string]interface{}
+		extraClaims map[string]interface{}
+		connection  *logical.Connection
+		wantErr     []string
+	}{
+		{
+			name:     "bound_subject match",
+			roleData: map[string]interface{}{"bound_subject": creds.ClientId},
+		},
+		{
+			name:     "bound_subject mismatch",
+			roleData: map[string]interface{}{"bound_subject": "not-the-subject"},
+			wantErr:  []string{"bound_subject"},
+		},
+		{
+			name:     "bound_audiences match",
+			roleData: map[string]interface{}{"bound_audiences": fmt.Sprintf(expectedJwtAudTemplate, "testgceboundrole")},
+		},
+		{
+			name:     "bound_audiences mismatch",
+			roleData: map[string]interface{}{"bound_audiences": "vault/some-other-role"},
+			wantErr:  []string{"bound_audiences"},
+		},
+		{
+			name:        "bound_claims match",
+			roleData:    map[string]interface{}{"bound_claims": map[string]interface{}{"team": "infra-*"}},
+			extraClaims: map[string]interface{}{"team": "infra-tools"},
+		},
+		{
+			name:        "bound_claims mismatch",
+			roleData:    map[string]interface{}{"bound_claims": map[string]interface{}{"team": "infra-*"}},
+			extraClaims: map[string]interface{}{"team": "payments"},
+			wantErr:     []string{"team"},
+		},
+		{
+			name:       "bound_cidrs match",
+			roleData:   map[string]interface{}{"bound_cidrs": "10.0.0.0/8"},
+			connection: &logical.Connection{RemoteAddr: "10.1.2.3"},
+		},
+		{
+			name:       "bound_cidrs mismatch",
+			roleData:   map[string]interface{}{"bound_cidrs": "10.0.0.0/8"},
+			connection: &logical.Connection{RemoteAddr: "192.168.1.1"},
+			wantErr:    []string{"bound_cidrs"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, reqStorage := getTestBackend(t)
+			testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+				"credentials": os.Getenv(googleCredentialsEnv),
+			})
+
+			roleName := "testgceboundrole"
+			roleData := map[string]interface{}{
+				"name":             roleName,
+				"type":             "iam",
+				"project_id":       creds.ProjectId,
+				"service_accounts": creds.ClientEmail,
+			}
+			for k, v := range tc.roleData {
+				roleData[k] = v
+			}
+			testRoleCreate(t, b, reqStorage, roleData)
+
+			jwtVal := createIamTokenWithClaims(t, roleName, creds, tc.extraClaims)
+			loginData := map[string]interface{}{
+				"role": roleName,
+				"jwt":  jwtVal,
+			}
+
+			if len(tc.wantErr) > 0 {
+				testLoginErrorWithConnection(t, b, reqStorage, loginData, tc.connection, tc.wantErr)
+				return
+			}
+
+			resp, err := b.HandleRequest(&logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Data:       loginData,
+				Storage:    reqStorage,
+				Connection: tc.connection,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp != nil && resp.IsError() {
+				t.Fatal(resp.Error())
+			}
+		})
+	}
+}
+
+// TestLoginIam_UserAndGroupsClaim checks that user_claim overrides the
+// default persona name and groups_claim populates Auth.GroupAliases.
+func TestLoginIam_UserAndGroupsClaim(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	creds, err := getTestCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"credentials": os.Getenv(googleCredentialsEnv),
+	})
+
+	roleName := "testgceuserclaimrole"
+	testRoleCreate(t, b, reqStorage, map[string]interface{}{
+		"name":             roleName,
+		"type":             "iam",
+		"project_id":       creds.ProjectId,
+		"service_accounts": creds.ClientEmail,
+		"user_claim":       "email",
+		"groups_claim":     "groups",
+	})
+
+	jwtVal := createIamTokenWithClaims(t, roleName, creds, map[string]interface{}{
+		"email":  "alice@example.com",
+		"groups": []interface{}{"team-a", "team-b"},
+	})
+	loginData := map[string]interface{}{
+		"role": roleName,
+		"jwt":  jwtVal,
+	}
+
+	metadata := map[string]string{
+		"service_account_id":    creds.ClientId,
+		"service_account_email": creds.ClientEmail,
+		"role":                  roleName,
+	}
+	role := &gcpRole{
+		RoleType:        "iam",
+		ProjectId:       creds.ProjectId,
+		Policies:        []string{"default"},
+		ServiceAccounts: []string{creds.ClientEmail},
+	}
+	testLoginIam(t, b, reqStorage, loginData, metadata, role, "alice@example.com", "team-a", "team-b")
+}
@@ -0,0 +1,350 @@
+package gcpauth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathRole(b *GcpAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+			},
+			"type": {
+				Type:        framework.TypeString,
+				Description: `Type of the role. Must be either "iam" or "gce".`,
+			},
+			"project_id": {
+				Type:        framework.TypeString,
+				Description: "GCP project ID that logins against this role must belong to.",
+			},
+			"policies": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of policies granted on a successful login.",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Default lease TTL for tokens issued against this role.",
+			},
+			"max_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Max lease TTL for tokens issued against this role.",
+			},
+			"period": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Period, if any, for tokens issued against this role.",
+			},
+			"max_jwt_exp": {
+				Type:        framework.TypeDurationSecond,
+				Default:     defaultMaxJwtExpMin * 60,
+				Description: "Maximum allowed lifetime, in seconds, of an inbound login JWT.",
+			},
+			"allow_jwt_reuse": {
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, disables replay protection for this role, allowing the same login JWT to be used more than once.",
+			},
+			"bound_subject": {
+				Type:        framework.TypeString,
+				Description: "If set, requires the JWT's 'sub' claim to exactly match this value.",
+			},
+			"bound_audiences": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of 'aud' claim values; at least one must appear in the JWT.",
+			},
+			"bound_claims": {
+				Type:        framework.TypeMap,
+				Description: "Map of claim name to allowed value(s) (string or list of strings, glob patterns allowed) the JWT must satisfy.",
+			},
+			"bound_cidrs": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of CIDR blocks the login request's remote address must belong to.",
+			},
+			"user_claim": {
+				Type:        framework.TypeString,
+				Description: "JWT claim used to populate Auth.Persona.Name, instead of the default service account/instance ID.",
+			},
+			"groups_claim": {
+				Type:        framework.TypeString,
+				Description: "JWT claim, if any, whose value(s) become additional Vault group aliases.",
+			},
+			"refresh_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "If set on an iam role, login also returns a refresh token valid for this long, redeemable at login/refresh to renew past max_ttl.",
+			},
+
+			// IAM role fields.
+			"service_accounts": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list (or "*") of service account emails/IDs allowed to login against this role.`,
+			},
+
+			// GCE role fields.
+			"bound_projects": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of GCP project IDs a GCE instance must belong to.",
+			},
+			"bound_zones": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of zones a GCE instance must be running in.",
+			},
+			"bound_regions": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of regions a GCE instance's zone must belong to.",
+			},
+			"bound_instance_groups": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of instance groups a GCE instance must belong to.",
+			},
+			"bound_labels": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of "key:value" labels a GCE instance must have.`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRoleCreateUpdate,
+			logical.CreateOperation: b.pathRoleCreateUpdate,
+			logical.ReadOperation:   b.pathRoleRead,
+			logical.DeleteOperation: b.pathRoleDelete,
+		},
+		HelpSynopsis:    "Create, read, update and delete roles.",
+		HelpDescription: "This path allows you to manage roles used to generate Vault tokens for GCP-authenticated callers.",
+	}
+}
+
+func pathRoleList(b *GcpAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/?",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathRoleList,
+		},
+		HelpSynopsis: "List the existing roles.",
+	}
+}
+
+func (b *GcpAuthBackend) pathRoleCreateUpdate(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("name is required"), nil
+	}
+
+	role, err := b.role(req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		role = &gcpRole{}
+	}
+
+	if v, ok := data.GetOk("type"); ok {
+		role.RoleType = v.(string)
+	}
+	if role.RoleType == "" {
+		role.RoleType = iamRoleType
+	}
+	if role.RoleType != iamRoleType && role.RoleType != gceRoleType {
+		return logical.ErrorResponse(fmt.Sprintf("invalid role type %q, must be %q or %q", role.RoleType, iamRoleType, gceRoleType)), nil
+	}
+
+	if v, ok := data.GetOk("project_id"); ok {
+		role.ProjectId = v.(string)
+	}
+	if v, ok := data.GetOk("policies"); ok {
+		role.Policies = v.([]string)
+	}
+	role.Policies = append(role.Policies, "default")
+	role.Policies = dedupStrings(role.Policies)
+
+	if v, ok := data.GetOk("ttl"); ok {
+		role.TTL = time.Duration(v.(int)) * time.Second
+	}
+	if v, ok := data.GetOk("max_ttl"); ok {
+		role.MaxTTL = time.Duration(v.(int)) * time.Second
+	}
+	if v, ok := data.GetOk("period"); ok {
+		role.Period = time.Duration(v.(int)) * time.Second
+	}
+	if v, ok := data.GetOk("max_jwt_exp"); ok {
+		role.MaxJwtExp = time.Duration(v.(int)) * time.Second
+	}
+	if v, ok := data.GetOk("allow_jwt_reuse"); ok {
+		role.AllowJwtReuse = v.(bool)
+	}
+	if v, ok := data.GetOk("bound_subject"); ok {
+		role.BoundSubject = v.(string)
+	}
+	if v, ok := data.GetOk("bound_audiences"); ok {
+		role.BoundAudiences = v.([]string)
+	}
+	if v, ok := data.GetOk("bound_claims"); ok {
+		boundClaims, err := parseBoundClaims(v.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid bound_claims: %v", err)), nil
+		}
+		role.BoundClaims = boundClaims
+	}
+	if v, ok := data.GetOk("bound_cidrs"); ok {
+		role.BoundCIDRs = v.([]string)
+	}
+	if v, ok := data.GetOk("user_claim"); ok {
+		role.UserClaim = v.(string)
+	}
+	if v, ok := data.GetOk("groups_claim"); ok {
+		role.GroupsClaim = v.(string)
+	}
+	if v, ok := data.GetOk("refresh_ttl"); ok {
+		role.RefreshTTL = time.Duration(v.(int)) * time.Second
+	}
+
+	switch role.RoleType {
+	case iamRoleType:
+		if v, ok := data.GetOk("service_accounts"); ok {
+			role.ServiceAccounts = v.([]string)
+		}
+		if len(role.ServiceAccounts) == 0 {
+			return logical.ErrorResponse("service_accounts must be set for an iam role"), nil
+		}
+	case gceRoleType:
+		if v, ok := data.GetOk("bound_projects"); ok {
+			role.BoundProjects = v.([]string)
+		}
+		if v, ok := data.GetOk("bound_zones"); ok {
+			role.BoundZones = v.([]string)
+		}
+		if v, ok := data.GetOk("bound_regions"); ok {
+			role.BoundRegions = v.([]string)
+		}
+		if v, ok := data.GetOk("bound_instance_groups"); ok {
+			role.BoundInstanceGroups = v.([]string)
+		}
+		if v, ok := data.GetOk("bound_labels"); ok {
+			role.BoundLabels = parseLabels(v.([]string))
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("role/%s", name), role)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *GcpAuthBackend) pathRoleRead(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.role(req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"type":                  role.RoleType,
+			"project_id":            role.ProjectId,
+			"policies":              role.Policies,
+			"ttl":                   role.TTL / time.Second,
+			"max_ttl":               role.MaxTTL / time.Second,
+			"period":                role.Period / time.Second,
+			"max_jwt_exp":           role.MaxJwtExp / time.Second,
+			"allow_jwt_reuse":       role.AllowJwtReuse,
+			"bound_subject":         role.BoundSubject,
+			"bound_audiences":       role.BoundAudiences,
+			"bound_claims":          role.BoundClaims,
+			"bound_cidrs":           role.BoundCIDRs,
+			"user_claim":            role.UserClaim,
+			"groups_claim":          role.GroupsClaim,
+			"refresh_ttl":           role.RefreshTTL / time.Second,
+			"service_accounts":      role.ServiceAccounts,
+			"bound_projects":        role.BoundProjects,
+			"bound_zones":           role.BoundZones,
+			"bound_regions":         role.BoundRegions,
+			"bound_instance_groups": role.BoundInstanceGroups,
+			"bound_labels":          role.BoundLabels,
+		},
+	}, nil
+}
+
+func (b *GcpAuthBackend) pathRoleDelete(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return nil, req.Storage.Delete(fmt.Sprintf("role/%s", data.Get("name").(string)))
+}
+
+func (b *GcpAuthBackend) pathRoleList(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roles, err := req.Storage.List("role/")
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(roles), nil
+}
+
+func dedupStrings(in []string) []string {
+	seen := make(map[string]struct{}, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		out = append(out, s)
+	}
+	return out
+}
+
+// parseLabels parses "key:value" pairs into a map, as used by bound_labels.
+func parseLabels(pairs []string) map[string]string {
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		kv := splitLabel(pair)
+		if kv[0] == "" {
+			continue
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels
+}
+
+// parseBoundClaims normalizes bound_claims values, which may be given as
+// either a single string or a list of strings, into map[string][]string.
+func parseBoundClaims(raw map[string]interface{}) (map[string][]string, error) {
+	claims := make(map[string][]string, len(raw))
+	for k, v := range raw {
+		switch vals := v.(type) {
+		case string:
+			claims[k] = []string{vals}
+		case []string:
+			claims[k] = vals
+		case []interface{}:
+			strs := make([]string, 0, len(vals))
+			for _, item := range vals {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("claim %q: expected string values, got %T", k, item)
+				}
+				strs = append(strs, s)
+			}
+			claims[k] = strs
+		default:
+			return nil, fmt.Errorf("claim %q: expected a string or list of strings, got %T", k, v)
+		}
+	}
+	return claims, nil
+}
+
+func splitLabel(pair string) [2]string {
+	for i := 0; i < len(pair); i++ {
+		if pair[i] == ':' {
+			return [2]string{pair[:i], pair[i+1:]}
+		}
+	}
+	return [2]string{"", ""}
+}
@@ -0,0 +1,63 @@
+package gcpauth
+
+import "time"
+
+const (
+	iamRoleType = "iam"
+	gceRoleType = "gce"
+)
+
+// gcpRole is a Vault role that binds a set of Vault policies to a set of
+// GCP identities, authenticated by either IAM-signed JWTs or GCE instance
+// identity tokens (determined by RoleType).
+type gcpRole struct {
+	RoleType string `json:"role_type" structs:"role_type" mapstructure:"role_type"`
+
+	// Common fields across both role types.
+	Policies []string      `json:"policies" structs:"policies" mapstructure:"policies"`
+	TTL      time.Duration `json:"ttl" structs:"ttl" mapstructure:"ttl"`
+	MaxTTL   time.Duration `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
+	Period   time.Duration `json:"period" structs:"period" mapstructure:"period"`
+
+	// ProjectId restricts logins to callers whose identity belongs to this
+	// GCP project. Used by both role types.
+	ProjectId string `json:"project_id" structs:"project_id" mapstructure:"project_id"`
+
+	// MaxJwtExp is the maximum allowed "exp" on an inbound login JWT,
+	// expressed as a duration from now. Used by both role types.
+	MaxJwtExp time.Duration `json:"max_jwt_exp" structs:"max_jwt_exp" mapstructure:"max_jwt_exp"`
+
+	// AllowJwtReuse disables replay protection for this role, allowing the
+	// same login JWT to be used more than once before it expires.
+	AllowJwtReuse bool `json:"allow_jwt_reuse" structs:"allow_jwt_reuse" mapstructure:"allow_jwt_reuse"`
+
+	// RefreshTTL, if set on an iam role, causes login to also return an
+	// opaque refresh token that can be redeemed at login/refresh to mint a
+	// new Vault token past max_ttl, without the caller re-signing a JWT.
+	RefreshTTL time.Duration `json:"refresh_ttl" structs:"refresh_ttl" mapstructure:"refresh_ttl"`
+
+	// Bound claim constraints, borrowed from Vault's JWT auth backend.
+	// Used by both role types.
+	BoundSubject   string              `json:"bound_subject" structs:"bound_subject" mapstructure:"bound_subject"`
+	BoundAudiences []string            `json:"bound_audiences" structs:"bound_audiences" mapstructure:"bound_audiences"`
+	BoundClaims    map[string][]string `json:"bound_claims" structs:"bound_claims" mapstructure:"bound_claims"`
+	BoundCIDRs     []string            `json:"bound_cidrs" structs:"bound_cidrs" mapstructure:"bound_cidrs"`
+
+	// UserClaim is the JWT claim used to populate Auth.Persona.Name. If
+	// unset, the service account ID (iam) or instance ID (gce) is used.
+	UserClaim string `json:"user_claim" structs:"user_claim" mapstructure:"user_claim"`
+
+	// GroupsClaim is the JWT claim, if any, whose value(s) become
+	// additional Vault group aliases on Auth.GroupAliases.
+	GroupsClaim string `json:"groups_claim" structs:"groups_claim" mapstructure:"groups_claim"`
+
+	// IAM role fields.
+	ServiceAccounts []string `json:"service_accounts" structs:"service_accounts" mapstructure:"service_accounts"`
+
+	// GCE role fields.
+	BoundProjects       []string          `json:"bound_projects" structs:"bound_projects" mapstructure:"bound_projects"`
+	BoundZones          []string          `json:"bound_zones" structs:"bound_zones" mapstructure:"bound_zones"`
+	BoundRegions        []string          `json:"bound_regions" structs:"bound_regions" mapstructure:"bound_regions"`
+	BoundInstanceGroups []string          `json:"bound_instance_groups" structs:"bound_instance_groups" mapstructure:"bound_instance_groups"`
+	BoundLabels         map[string]string `json:"bound_labels" structs:"bound_labels" mapstructure:"bound_labels"`
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// iamCertsURLTemplate returns the x509 certs Google publishes for a given
+// service account, keyed by key ID ("kid").
+const iamCertsURLTemplate = "https://www.googleapis.com/robot/v1/metadata/x509/%s"
+
+// GetIamServiceAccountPublicKey fetches and parses the PEM-encoded x509
+// certificate Google publishes for the given service account's signing key.
+func GetIamServiceAccountPublicKey(serviceAccountEmail, kid string) (*rsa.PublicKey, error) {
+	resp, err := http.Get(fmt.Sprintf(iamCertsURLTemplate, serviceAccountEmail))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch public certs for %q: %v", serviceAccountEmail, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	certsByKid := map[string]string{}
+	if err := json.Unmarshal(body, &certsByKid); err != nil {
+		return nil, fmt.Errorf("could not parse certs response: %v", err)
+	}
+
+	pemCert, ok := certsByKid[kid]
+	if !ok {
+		return nil, fmt.Errorf("no public cert found for key id %q", kid)
+	}
+
+	return parseRSAPublicKeyFromCertPEM([]byte(pemCert))
+}
+
+func parseRSAPublicKeyFromCertPEM(pemBytes []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("could not decode PEM block containing certificate")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse certificate: %v", err)
+	}
+
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("certificate public key is not RSA")
+	}
+
+	return key, nil
+}